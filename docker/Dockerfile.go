@@ -1,15 +1,45 @@
 # Go Sandbox Agent
 # Optimized image for Go execution
 
+# Fetches each pinned Go release into its own /opt/go/<version> directory
+# so the agent can switch GOROOT per request instead of being locked to
+# a single toolchain baked into the base image.
+FROM alpine:3.19 AS toolchains
+
+RUN apk add --no-cache curl
+ARG GOARCH=amd64
+ARG GO_VERSIONS="1.21.13 1.22.9 1.23.4"
+RUN for v in $GO_VERSIONS; do \
+      mkdir -p /opt/go/${v} && \
+      curl -fsSL "https://go.dev/dl/go${v}.linux-${GOARCH}.tar.gz" | tar -xz -C /opt/go/${v} --strip-components=1; \
+    done
+
 FROM node:20-alpine AS node-builder
 
 WORKDIR /app
 COPY sandbox-agent/package*.json ./
 RUN npm ci --only=production && npm cache clean --force
 
-FROM golang:1.22-alpine
+FROM alpine:3.19
+
+RUN apk add --no-cache nodejs npm tini git isolate bash && rm -rf /var/cache/apk/*
+
+COPY --from=toolchains /opt/go /opt/go
+
+# gVisor's runsc OCI runtime, invoked directly via `runsc run --bundle=...`
+# (no Docker daemon involved) when SANDBOX_RUNTIME=runsc.
+RUN ARCH=$(uname -m | sed 's/x86_64/x86_64/;s/aarch64/aarch64/') && \
+    wget -q "https://storage.googleapis.com/gvisor/releases/release/latest/${ARCH}/runsc" \
+      -O /usr/local/bin/runsc && \
+    chmod +x /usr/local/bin/runsc
 
-RUN apk add --no-cache nodejs npm tini git && rm -rf /var/cache/apk/*
+# Prebuilt OCI bundle rootfs shared by every runsc-isolated execution:
+# a copy of this same image's filesystem, so the sandboxed process sees
+# the same Go toolchains without a Docker daemon to pull/export an image.
+RUN mkdir -p /opt/runsc-bundle/rootfs && \
+    tar -C / --exclude=./proc --exclude=./sys --exclude=./dev --exclude=./opt/runsc-bundle \
+      -cf - . | tar -C /opt/runsc-bundle/rootfs -xf - && \
+    mkdir -p /opt/runsc-bundle/rootfs/proc /opt/runsc-bundle/rootfs/sys /opt/runsc-bundle/rootfs/dev
 
 RUN addgroup -g 1001 -S sandbox && \
     adduser -u 1001 -S sandbox -G sandbox
@@ -19,17 +49,73 @@ WORKDIR /app
 COPY --from=node-builder /app/node_modules ./node_modules
 COPY sandbox-agent/package.json ./
 COPY sandbox-agent/src ./src
+COPY proto /proto
+COPY docker/playground/fake_fs.lst /app/playground/fake_fs.lst
+COPY docker/entrypoint.sh /app/entrypoint.sh
 
-RUN mkdir -p /app/data /home/sandbox/go && \
-    chown -R sandbox:sandbox /app /home/sandbox
+# /opt/go needs to be sandbox-owned too: entrypoint.sh runs as USER
+# sandbox and git-clones/builds gotip straight into
+# /opt/go/tip when SANDBOX_ENABLE_TIP=1, which would otherwise fail with
+# EACCES against the root-owned directory the toolchains stage copied in.
+RUN mkdir -p /app/data /home/sandbox/go /home/sandbox/pool && \
+    chmod +x /app/entrypoint.sh && \
+    chown -R sandbox:sandbox /app /home/sandbox /opt/go
 
 ENV NODE_ENV=production
 ENV SANDBOX_LANGUAGE=go
 ENV GOPATH=/home/sandbox/go
 ENV GOCACHE=/home/sandbox/go/cache
 
-HEALTHCHECK --interval=30s --timeout=3s --start-period=5s --retries=3 \
-    CMD node -e "console.log('healthy')" || exit 1
+# Toolchains installed side-by-side under /opt/go/<version> (see the
+# "toolchains" build stage above). A request's go_version picks one;
+# unset/unknown falls back to SANDBOX_GO_DEFAULT_VERSION. Enabling
+# SANDBOX_ENABLE_TIP makes the entrypoint build a nightly gotip slot
+# into /opt/go/tip on container start.
+ENV SANDBOX_GO_VERSIONS_DIR=/opt/go
+ENV SANDBOX_GO_DEFAULT_VERSION=1.22.9
+ENV SANDBOX_ENABLE_TIP=0
+ENV GOROOT=/opt/go/1.22.9
+ENV PATH="/opt/go/1.22.9/bin:${PATH}"
+
+# Isolation level for executing user code: "native" runs `go run` directly
+# as the sandbox user; "runsc" runs it via `runsc run` against the
+# SANDBOX_RUNSC_BUNDLE built above, with no Docker daemon involved.
+# SANDBOX_NETWORK and the mem/cpu limits shape that sandbox's resources.
+ENV SANDBOX_RUNTIME=native
+ENV SANDBOX_RUNSC_BUNDLE=/opt/runsc-bundle
+ENV SANDBOX_NETWORK=none
+ENV SANDBOX_MEM_LIMIT=256m
+ENV SANDBOX_CPU_LIMIT=1
+
+# gRPC streaming Execute() API, alongside the HTTP port.
+ENV GRPC_PORT=9090
+EXPOSE 9090
+
+# Session-pinned pool of pre-warmed workspaces (sbx-0..sbx-N-1 under
+# SANDBOX_POOL_DIR) plus the per-run isolate caps used when
+# SANDBOX_RUNTIME=isolate.
+ENV SANDBOX_POOL_DIR=/home/sandbox/pool
+ENV SANDBOX_POOL_SIZE=8
+ENV SANDBOX_POOL_TTL_MS=600000
+ENV SANDBOX_ISOLATE_TIME=5
+ENV SANDBOX_ISOLATE_WALL_TIME=10
+ENV SANDBOX_ISOLATE_MEM=262144
+ENV SANDBOX_ISOLATE_PROCESSES=32
+
+# "playground" mode adds Go Playground-style determinism on top of
+# whichever SANDBOX_RUNTIME is selected: a frozen virtual clock (built on the
+# stdlib's faketime tag) and a fake filesystem seeded from a manifest.
+ENV SANDBOX_MODE=normal
+ENV SANDBOX_PLAYGROUND_EPOCH_NS=1257894000000000000
+ENV SANDBOX_FAKE_FS_MANIFEST=/app/playground/fake_fs.lst
+
+# start-period covers the worst case, not the common one: when
+# SANDBOX_ENABLE_TIP=1, docker/entrypoint.sh blocks container startup on
+# a full `git clone` + `make.bash` build of gotip before it ever execs
+# into `node src/index.js`, and that shouldn't be mistaken for a crashed
+# container mid-bootstrap.
+HEALTHCHECK --interval=30s --timeout=3s --start-period=600s --retries=3 \
+    CMD node -e "require('net').connect(process.env.GRPC_PORT || 9090, '127.0.0.1').on('connect', function () { process.exit(0); }).on('error', function () { process.exit(1); });" || exit 1
 
 LABEL maintainer="Insien <dev@insien.com>" \
       version="1.0.0" \
@@ -37,5 +123,5 @@ LABEL maintainer="Insien <dev@insien.com>" \
 
 USER sandbox
 
-ENTRYPOINT ["/sbin/tini", "--"]
+ENTRYPOINT ["/sbin/tini", "--", "/app/entrypoint.sh"]
 CMD ["node", "src/index.js"]